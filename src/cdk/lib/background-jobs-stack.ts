@@ -35,13 +35,22 @@ export class BackgroundJobsStack extends cdk.Stack {
             targets: [
                 new LambdaFunction(lambda, {
                     event: RuleTargetInput.fromObject({
-                        version: '2022-11-05',
-                        jobs: [
-                            {name: 'DELETE_EXPIRED_SESSIONS'},
-                            {name: 'DELETE_EXPIRED_AUTHORIZATIONS'},
-                            // {name: 'UPDATE_API_TOKEN_VALIDITY'},
-                            // {name: 'RETRY_VERIFICATION_CHALLENGES'}
-                        ]
+                        version: '2026-10-16',
+                        schedule: 'HOURLY'
+                    }),
+                    retryAttempts: 0
+                })
+            ],
+            enabled: false
+        });
+
+        new Rule(this, 'Gw2AuthBackgroundJobsLambdaDailyScheduleRule', {
+            schedule: Schedule.rate(Duration.days(1)),
+            targets: [
+                new LambdaFunction(lambda, {
+                    event: RuleTargetInput.fromObject({
+                        version: '2026-10-16',
+                        schedule: 'DAILY'
                     }),
                     retryAttempts: 0
                 })
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// jobBudgetReserve is kept free at the end of an invocation to record results and return the summary
+const jobBudgetReserve = time.Second * 5
+
+// withJobBudget derives the context for the first of the given pending jobs. Its deadline is the share of the
+// remaining time proportional to the job's weight among all pending jobs. Since the share is computed from the
+// remaining time, time left unused by a job is passed on to the jobs after it.
+// If ctx carries no deadline, it is returned as is.
+func withJobBudget(ctx context.Context, pending []JobExecutionRequest) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || len(pending) < 1 {
+		return context.WithCancel(ctx)
+	}
+
+	remaining := time.Until(deadline) - jobBudgetReserve
+	if remaining <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	totalWeight := 0.0
+	for _, job := range pending {
+		totalWeight += job.weight()
+	}
+
+	share := time.Duration(float64(remaining) * (pending[0].weight() / totalWeight))
+	return context.WithTimeout(ctx, share)
+}
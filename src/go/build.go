@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"runtime/debug"
+)
+
+// buildVersion returns the VCS revision the binary was built from, or "unknown" if it wasn't recorded
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return setting.Value[:12]
+		}
+	}
+
+	return "unknown"
+}
+
+func randomId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+	"time"
+)
+
+const defaultAccountCountsBatchSize = 1000
+
+type refreshAccountCountsParams struct {
+	// BatchSize is the number of accounts whose count is refreshed per statement
+	BatchSize int `json:"batchSize"`
+}
+
+type refreshAccountCountsResult struct {
+	Refreshed int64 `json:"refreshed"`
+	Removed   int64 `json:"removed"`
+	Complete  bool  `json:"complete"`
+}
+
+// refreshAccountCounts recomputes the number of connected GW2 accounts per account and stores it in
+// account_gw2_account_counts. Accounts are processed in batches ordered by account_id. Counts of accounts
+// without any GW2 account left are only removed once a run got through all accounts.
+func refreshAccountCounts(ctx context.Context, db *dbConn, job JobExecutionRequest) (any, error) {
+	params := refreshAccountCountsParams{BatchSize: defaultAccountCountsBatchSize}
+	if err := job.decodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	if params.BatchSize < 1 {
+		return nil, errors.New("batchSize must be positive")
+	}
+
+	// truncated so the comparison below is not affected by the database's timestamp precision
+	startedAt := time.Now().Truncate(time.Microsecond)
+	lastAccountId := "00000000-0000-0000-0000-000000000000"
+	var result refreshAccountCountsResult
+
+	for {
+		var count int
+		err := retryDb(ctx, db, func(conn *pgx.Conn) error {
+			return conn.QueryRow(
+				ctx,
+				`
+WITH counts AS (
+    INSERT INTO account_gw2_account_counts (account_id, gw2_account_count, update_time)
+    SELECT account_id, COUNT(DISTINCT gw2_account_id), $2
+    FROM gw2_account_api_tokens
+    WHERE account_id > $1
+    GROUP BY account_id
+    ORDER BY account_id
+    LIMIT $3
+    ON CONFLICT (account_id) DO UPDATE
+    SET gw2_account_count = excluded.gw2_account_count, update_time = excluded.update_time
+    RETURNING account_id
+)
+SELECT COUNT(*), COALESCE(MAX(account_id::STRING), '') FROM counts
+`,
+				lastAccountId,
+				startedAt,
+				params.BatchSize,
+			).Scan(&count, &lastAccountId)
+		})
+		if err != nil {
+			return result, err
+		}
+
+		result.Refreshed += int64(count)
+
+		if count < params.BatchSize {
+			break
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < deleteDeadlineMargin {
+			slog.WarnContext(ctx, "stopping early because the deadline is near", slog.Int64("refreshed", result.Refreshed))
+			return result, nil
+		}
+	}
+
+	removed, err := deleteInChunks(ctx, db, "DELETE FROM account_gw2_account_counts WHERE update_time < $1 LIMIT $2", startedAt)
+	result.Removed = removed
+	result.Complete = err == nil
+
+	slog.InfoContext(ctx, "refreshed account counts", slog.Int64("refreshed", result.Refreshed), slog.Int64("removed", result.Removed))
+	return result, err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gw2auth/background-jobs/internal/retry"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultDbConnectAttempts = 3
+	defaultDbConnectMaxDelay = time.Second * 5
+	dbConnectBaseDelay       = time.Millisecond * 500
+	dbPingTimeout            = time.Second * 5
+)
+
+// connectDb connects to the database and verifies the connection with a ping.
+// Failures are retried with exponential backoff, unless the server itself rejected the connection (e.g. bad credentials).
+// The number of attempts and the maximum delay can be configured using
+// GW2AUTH_DB_CONNECT_ATTEMPTS and GW2AUTH_DB_CONNECT_MAX_DELAY.
+func connectDb(ctx context.Context, connString string) (*pgx.Conn, error) {
+	policy := retry.Policy{
+		MaxAttempts: envInt("GW2AUTH_DB_CONNECT_ATTEMPTS", defaultDbConnectAttempts),
+		BaseDelay:   dbConnectBaseDelay,
+		MaxDelay:    envDuration("GW2AUTH_DB_CONNECT_MAX_DELAY", defaultDbConnectMaxDelay),
+		Jitter:      0.2,
+	}
+
+	var conn *pgx.Conn
+	err := retry.Do(
+		ctx,
+		policy,
+		func() error {
+			var err error
+			if conn, err = pgx.Connect(ctx, connString); err != nil {
+				return err
+			}
+
+			if err = pingDb(ctx, conn); err != nil {
+				_ = conn.Close(context.Background())
+				conn = nil
+			}
+
+			return err
+		},
+		func(err error) bool {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) {
+				return false
+			}
+
+			slog.WarnContext(ctx, "failed to connect to the database, retrying", slog.String("err", err.Error()))
+			return true
+		},
+	)
+
+	return conn, err
+}
+
+// dbConn holds the database connection shared by all jobs of an invocation.
+// pgx closes a connection when a context expires mid-query, so a job hitting its timeout leaves a closed
+// connection behind; get transparently reconnects in that case so the following jobs are not affected.
+type dbConn struct {
+	connString string
+	conn       *pgx.Conn
+}
+
+// get returns an open connection, reconnecting if the previous one was closed
+func (db *dbConn) get(ctx context.Context) (*pgx.Conn, error) {
+	if db.conn != nil && !db.conn.IsClosed() {
+		return db.conn, nil
+	}
+
+	if db.conn != nil {
+		slog.WarnContext(ctx, "database connection was closed, reconnecting")
+	}
+
+	conn, err := connectDb(ctx, db.connString)
+	if err != nil {
+		return nil, err
+	}
+
+	db.conn = conn
+	return conn, nil
+}
+
+func (db *dbConn) close() {
+	if db.conn != nil {
+		_ = db.conn.Close(context.Background())
+		db.conn = nil
+	}
+}
+
+// pingDb verifies the connection actually works before any job runs
+func pingDb(ctx context.Context, conn *pgx.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+	defer cancel()
+
+	if err := conn.Ping(ctx); err != nil {
+		return fmt.Errorf("database did not respond to ping within %v: %w", dbPingTimeout, err)
+	}
+
+	return nil
+}
+
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+
+	return def
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+
+	return def
+}
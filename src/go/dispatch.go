@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// dispatch runs all requested jobs one after another, each within its own share of the time budget
+// and its own timeout. A failing job does not stop the remaining jobs: the summary always contains the
+// result of every job, and the returned *ExecutionError joins the errors of all failed jobs.
+func dispatch(ctx context.Context, db *dbConn, requests []JobExecutionRequest) (*ExecutionSummary, error) {
+	summary := ExecutionSummary{Jobs: make([]JobExecutionResult, 0, len(requests))}
+	errs := make([]error, 0)
+
+	for i, job := range requests {
+		jobCtx, cancel := withJobBudget(withLogAttrs(ctx, slog.String("job.name", job.Name)), requests[i:])
+		jobCtx, cancelTimeout := context.WithTimeout(jobCtx, job.timeout())
+		result, err := executeJob(jobCtx, db, job)
+		cancelTimeout()
+		cancel()
+
+		if err != nil {
+			if result.Status == jobStatusTimeout {
+				slog.WarnContext(ctx, "job exceeded its timeout", slog.String("job.name", job.Name), slog.Duration("timeout", job.timeout()))
+			} else {
+				result.Status = jobStatusFailure
+				slog.ErrorContext(ctx, "job failed", slog.String("job.name", job.Name), slog.String("err", err.Error()))
+			}
+
+			result.Error = err.Error()
+			errs = append(errs, fmt.Errorf("job %s: %w", job.Name, err))
+		}
+
+		summary.Jobs = append(summary.Jobs, result)
+	}
+
+	if len(errs) > 0 {
+		return &summary, &ExecutionError{Summary: &summary, Err: errors.Join(errs...)}
+	}
+
+	return &summary, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// versionLayout is the format of ScheduledExecutionEvent.Version
+const versionLayout = "2006-01-02"
+
+var (
+	// minSupportedVersion is the oldest event format this binary understands
+	minSupportedVersion = mustParseVersion("2022-11-05")
+	// maxSupportedVersion is the newest event format this binary understands
+	maxSupportedVersion = mustParseVersion("2026-10-16")
+)
+
+type JobExecutionRequest struct {
+	Name   string          `json:"name"`
+	Params json.RawMessage `json:"params,omitempty"`
+	// Cooldown skips the job if it finished successfully within this duration
+	Cooldown Duration `json:"cooldown,omitempty"`
+	// Weight is the relative share of the invocation's time budget this job gets (defaults to 1)
+	Weight float64 `json:"weight,omitempty"`
+	// Timeout overrides the default timeout of the job
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+func (job JobExecutionRequest) timeout() time.Duration {
+	if job.Timeout > 0 {
+		return time.Duration(job.Timeout)
+	}
+
+	if def, ok := jobs[job.Name]; ok && def.timeout > 0 {
+		return def.timeout
+	}
+
+	return defaultJobTimeout
+}
+
+func (job JobExecutionRequest) weight() float64 {
+	if job.Weight <= 0 {
+		return 1
+	}
+
+	return job.Weight
+}
+
+type ScheduledExecutionEvent struct {
+	Version string `json:"version"`
+	// Schedule names one of the predefined job sets in schedules. It is mutually exclusive with Jobs.
+	Schedule string                `json:"schedule,omitempty"`
+	Jobs     []JobExecutionRequest `json:"jobs"`
+}
+
+// schedules are the job sets which can be requested by name using ScheduledExecutionEvent.Schedule
+var schedules = map[string][]JobExecutionRequest{
+	"HOURLY": {
+		{Name: "DELETE_EXPIRED_SESSIONS"},
+		{Name: "DELETE_EXPIRED_AUTHORIZATIONS"},
+		{Name: "REFRESH_SUBTOKENS"},
+		{Name: "REFRESH_ACCOUNT_COUNTS"},
+		// {Name: "UPDATE_API_TOKEN_VALIDITY"},
+		// {Name: "RETRY_VERIFICATION_CHALLENGES"},
+	},
+	// jobs deleting user data are not scheduled by default; adding them here must be a deliberate decision
+	"DAILY": {
+		// {Name: "DELETE_STALE_TOKENS"},
+		// {Name: "DELETE_ORPHANED_GW2_ACCOUNTS"},
+	},
+}
+
+// jobRequests returns the jobs to run for this event, either from its schedule or its explicit job list
+func (event ScheduledExecutionEvent) jobRequests() ([]JobExecutionRequest, error) {
+	if event.Schedule == "" {
+		return event.Jobs, nil
+	}
+
+	if len(event.Jobs) > 0 {
+		return nil, errors.New("schedule and jobs must not be set both")
+	}
+
+	requests, ok := schedules[event.Schedule]
+	if !ok {
+		return nil, errors.New("unknown schedule: " + event.Schedule)
+	}
+
+	return requests, nil
+}
+
+// checkEventVersion verifies the version of an incoming event is a date within the supported range
+func checkEventVersion(v string) error {
+	parsed, err := time.Parse(versionLayout, v)
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", v, err)
+	}
+
+	if parsed.Before(minSupportedVersion) || parsed.After(maxSupportedVersion) {
+		return fmt.Errorf("unsupported version %s, supported are %s to %s", v, minSupportedVersion.Format(versionLayout), maxSupportedVersion.Format(versionLayout))
+	}
+
+	return nil
+}
+
+func mustParseVersion(v string) time.Time {
+	parsed, err := time.Parse(versionLayout, v)
+	if err != nil {
+		panic(err)
+	}
+
+	return parsed
+}
+
+// JobExecutionResult is the outcome of a single job within an ExecutionSummary
+type JobExecutionResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ExecutionSummary is the response of an invocation
+type ExecutionSummary struct {
+	Jobs []JobExecutionResult `json:"jobs"`
+}
+
+// ExecutionError is returned if at least one job failed. The Lambda runtime drops the response
+// of failed invocations, so the error message carries the summary as JSON instead.
+type ExecutionError struct {
+	Summary *ExecutionSummary
+	Err     error
+}
+
+func (e *ExecutionError) Error() string {
+	summaryJson, err := json.Marshal(e.Summary)
+	if err != nil {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("%v; summary: %s", e.Err, summaryJson)
+}
+
+func (e *ExecutionError) Unwrap() error {
+	return e.Err
+}
+
+// Duration is a time.Duration which is represented as a string (e.g. "720h") in JSON
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	if v < 0 {
+		return errors.New("duration must not be negative: " + s)
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// decodeParams unmarshals the params of the given job into v.
+// Absent params leave v untouched, unknown fields are rejected.
+func (job JobExecutionRequest) decodeParams(v any) error {
+	if len(job.Params) == 0 || bytes.Equal(job.Params, []byte("null")) {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(job.Params))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid params for job %s: %w", job.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckEventVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		// wantErr is a substring of the expected error, empty if the version must be accepted
+		wantErr string
+	}{
+		{version: "2022-11-05"},
+		{version: "2026-10-16"},
+		{version: "2024-02-29"},
+		{version: "2022-11-04", wantErr: "unsupported version"},
+		{version: "2026-10-17", wantErr: "unsupported version"},
+		{version: "1970-01-01", wantErr: "unsupported version"},
+		{version: "9999-12-31", wantErr: "unsupported version"},
+		{version: "2023-02-29", wantErr: "invalid version"},
+		{version: "2024-13-01", wantErr: "invalid version"},
+		{version: "2024-1-01", wantErr: "invalid version"},
+		{version: "2024/01/01", wantErr: "invalid version"},
+		{version: "", wantErr: "invalid version"},
+		{version: " 2024-01-01", wantErr: "invalid version"},
+		{version: "2024-01-01T00:00:00Z", wantErr: "invalid version"},
+		{version: "2024-01-01Z", wantErr: "invalid version"},
+		{version: "2024-01-01+02:00", wantErr: "invalid version"},
+		{version: "2024-01-01 UTC", wantErr: "invalid version"},
+	}
+
+	for _, tt := range tests {
+		err := checkEventVersion(tt.version)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("checkEventVersion(%q) = %v, want nil", tt.version, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("checkEventVersion(%q) = nil, want error containing %q", tt.version, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("checkEventVersion(%q) = %v, want error containing %q", tt.version, err, tt.wantErr)
+		}
+	}
+}
+
+func FuzzCheckEventVersion(f *testing.F) {
+	for _, v := range []string{"2022-11-05", "2026-10-16", "2024-02-29", "2023-02-29", "2024-01-01T00:00:00Z", ""} {
+		f.Add(v)
+	}
+
+	f.Fuzz(func(t *testing.T, v string) {
+		if err := checkEventVersion(v); err != nil {
+			return
+		}
+
+		// every accepted version is a canonical date within the supported range
+		parsed, err := time.Parse(versionLayout, v)
+		if err != nil {
+			t.Fatalf("checkEventVersion(%q) accepted a version which does not parse: %v", v, err)
+		}
+
+		if parsed.Format(versionLayout) != v {
+			t.Errorf("checkEventVersion(%q) accepted a non-canonical version", v)
+		}
+
+		if parsed.Before(minSupportedVersion) || parsed.After(maxSupportedVersion) {
+			t.Errorf("checkEventVersion(%q) accepted a version outside of the supported range", v)
+		}
+	})
+}
+
+func TestCheckEventVersionBoundaries(t *testing.T) {
+	tests := []struct {
+		version time.Time
+		want    bool
+	}{
+		{version: minSupportedVersion.AddDate(-1, 0, 0), want: false},
+		{version: minSupportedVersion.AddDate(0, 0, -1), want: false},
+		{version: minSupportedVersion, want: true},
+		{version: minSupportedVersion.AddDate(0, 0, 1), want: true},
+		{version: maxSupportedVersion.AddDate(0, 0, -1), want: true},
+		{version: maxSupportedVersion, want: true},
+		{version: maxSupportedVersion.AddDate(0, 0, 1), want: false},
+		{version: maxSupportedVersion.AddDate(1, 0, 0), want: false},
+	}
+
+	for _, tt := range tests {
+		v := tt.version.Format(versionLayout)
+		if got := checkEventVersion(v) == nil; got != tt.want {
+			t.Errorf("checkEventVersion(%q) accepted = %v, want %v", v, got, tt.want)
+		}
+	}
+}
+
+// FuzzCheckEventVersionOrdering verifies that accepting or rejecting two valid dates agrees with their
+// chronological order: the accepted versions form a contiguous range from minSupportedVersion to maxSupportedVersion.
+func FuzzCheckEventVersionOrdering(f *testing.F) {
+	f.Add("2022-11-04", "2022-11-05")
+	f.Add("2026-10-16", "2026-10-17")
+	f.Add("2024-02-28", "2024-02-29")
+	f.Add("2000-01-01", "9999-12-31")
+
+	f.Fuzz(func(t *testing.T, a, b string) {
+		ta, errA := time.Parse(versionLayout, a)
+		tb, errB := time.Parse(versionLayout, b)
+		if errA != nil || errB != nil || ta.Format(versionLayout) != a || tb.Format(versionLayout) != b {
+			return
+		}
+
+		if tb.Before(ta) {
+			a, b, ta, tb = b, a, tb, ta
+		}
+
+		acceptedA, acceptedB := checkEventVersion(a) == nil, checkEventVersion(b) == nil
+		inRange := func(v time.Time) bool {
+			return !v.Before(minSupportedVersion) && !v.After(maxSupportedVersion)
+		}
+
+		if acceptedA != inRange(ta) || acceptedB != inRange(tb) {
+			t.Fatalf("checkEventVersion(%q) = %v, checkEventVersion(%q) = %v, want %v and %v", a, acceptedA, b, acceptedB, inRange(ta), inRange(tb))
+		}
+
+		// a <= b: a rejected version before an accepted one must precede the range, and vice versa
+		if !acceptedA && acceptedB && !ta.Before(minSupportedVersion) {
+			t.Errorf("%q is rejected although it is not before the accepted %q", a, b)
+		}
+
+		if acceptedA && !acceptedB && !tb.After(maxSupportedVersion) {
+			t.Errorf("%q is rejected although it is not after the accepted %q", b, a)
+		}
+	})
+}
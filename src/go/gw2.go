@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gw2auth/background-jobs/internal/retry"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// gw2MaxResponseSize is the maximum accepted size of a response body; the objects used here are much smaller
+	gw2MaxResponseSize = 16 * 1024
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid GW2 API token")
+	ErrMissingScope        = errors.New("GW2 API token is missing a required scope")
+	ErrRateLimited         = errors.New("rate limited by the GW2 API")
+	ErrUpstreamUnavailable = errors.New("GW2 API unavailable")
+	ErrAccountMismatch     = errors.New("GW2 API token belongs to a different account")
+)
+
+// Gw2ApiError is returned for every failed GW2 API request.
+// Use errors.Is with one of the Err* sentinels to branch on the kind of failure.
+type Gw2ApiError struct {
+	Path       string
+	RequestId  string
+	StatusCode int
+	Text       string
+	Err        error
+}
+
+func (e *Gw2ApiError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("GW2 API %s [%s]: %v", e.Path, e.RequestId, e.Err)
+	}
+
+	return fmt.Sprintf("GW2 API %s [%s] responded with %d (%s): %v", e.Path, e.RequestId, e.StatusCode, e.Text, e.Err)
+}
+
+func (e *Gw2ApiError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// gw2ApiBaseUrl is only changed by tests, to point at a fake GW2 API
+	gw2ApiBaseUrl  = "https://api.guildwars2.com"
+	gw2RetryPolicy = retry.Policy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Second * 5,
+		Jitter:      0.5,
+	}
+	gw2HttpClient = newGw2HttpClient()
+	gw2UserAgent  = "GW2Auth-BackgroundJobs/" + buildVersion() + " (+https://gw2auth.com)"
+)
+
+// newGw2HttpClient builds the HTTP client shared by all GW2 API calls. Its transport keeps enough idle
+// connections to the GW2 API to avoid reconnecting between calls. The pool size and timeouts can be
+// configured using GW2AUTH_GW2_MAX_IDLE_CONNS, GW2AUTH_GW2_IDLE_CONN_TIMEOUT, GW2AUTH_GW2_DIAL_TIMEOUT
+// and GW2AUTH_GW2_REQUEST_TIMEOUT.
+func newGw2HttpClient() *http.Client {
+	maxIdleConns := envInt("GW2AUTH_GW2_MAX_IDLE_CONNS", 16)
+	dialer := &net.Dialer{
+		Timeout:   envDuration("GW2AUTH_GW2_DIAL_TIMEOUT", time.Second*5),
+		KeepAlive: time.Second * 30,
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        maxIdleConns,
+			MaxIdleConnsPerHost: maxIdleConns,
+			IdleConnTimeout:     envDuration("GW2AUTH_GW2_IDLE_CONN_TIMEOUT", time.Second*90),
+			TLSHandshakeTimeout: time.Second * 5,
+		},
+		Timeout: envDuration("GW2AUTH_GW2_REQUEST_TIMEOUT", time.Second*10),
+	}
+}
+
+// gw2Get performs a GET request against the GW2 API and decodes the JSON response into out.
+// The token is only sent if it is not empty. Rate limited and unavailable responses are retried
+// according to gw2RetryPolicy. All failures are returned as *Gw2ApiError.
+func gw2Get(ctx context.Context, path string, query url.Values, token string, out any) error {
+	return retry.Do(
+		ctx,
+		gw2RetryPolicy,
+		func() error {
+			return gw2GetOnce(ctx, path, query, token, out)
+		},
+		func(err error) bool {
+			if !errors.Is(err, ErrRateLimited) && !errors.Is(err, ErrUpstreamUnavailable) {
+				return false
+			}
+
+			slog.WarnContext(ctx, "retrying GW2 API request", slog.String("err", err.Error()))
+			return true
+		},
+	)
+}
+
+func gw2GetOnce(ctx context.Context, path string, query url.Values, token string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gw2ApiBaseUrl+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	requestId := randomId()
+	req.Header.Set("User-Agent", gw2UserAgent)
+	req.Header.Set("X-Request-Id", requestId)
+
+	// the token is sent as header rather than query parameter so it never ends up in URLs printed by errors or logs
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := gw2HttpClient.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return &Gw2ApiError{Path: path, RequestId: requestId, Err: ctxErr}
+		}
+
+		// a *url.Error prints the full URL; the path is already part of Gw2ApiError
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+
+		return &Gw2ApiError{Path: path, RequestId: requestId, Err: fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)}
+	}
+	defer res.Body.Close()
+
+	// read one byte more than allowed to detect oversized responses
+	body, err := io.ReadAll(io.LimitReader(res.Body, gw2MaxResponseSize+1))
+	if err != nil {
+		return &Gw2ApiError{Path: path, RequestId: requestId, StatusCode: res.StatusCode, Err: fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)}
+	} else if len(body) > gw2MaxResponseSize {
+		return &Gw2ApiError{Path: path, RequestId: requestId, StatusCode: res.StatusCode, Err: fmt.Errorf("%w: response exceeds %d bytes", ErrUpstreamUnavailable, gw2MaxResponseSize)}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var errBody struct {
+			Text string `json:"text"`
+		}
+		_ = json.Unmarshal(body, &errBody)
+
+		return &Gw2ApiError{Path: path, RequestId: requestId, StatusCode: res.StatusCode, Text: errBody.Text, Err: classifyGw2Status(res.StatusCode, errBody.Text)}
+	}
+
+	if contentType := res.Header.Get("Content-Type"); !isJsonContentType(contentType) {
+		return &Gw2ApiError{Path: path, RequestId: requestId, StatusCode: res.StatusCode, Err: fmt.Errorf("%w: unexpected content type %q", ErrUpstreamUnavailable, contentType)}
+	}
+
+	if err = json.Unmarshal(body, out); err != nil {
+		return &Gw2ApiError{Path: path, RequestId: requestId, StatusCode: res.StatusCode, Err: fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)}
+	}
+
+	return nil
+}
+
+func classifyGw2Status(statusCode int, text string) error {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode >= 500 || statusCode == http.StatusPartialContent:
+		return ErrUpstreamUnavailable
+	case statusCode == http.StatusForbidden && strings.Contains(strings.ToLower(text), "requires scope"):
+		return ErrMissingScope
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrInvalidToken
+	default:
+		return fmt.Errorf("unexpected status %d", statusCode)
+	}
+}
+
+// isJsonContentType reports whether the Content-Type header value denotes JSON, ignoring parameters like charset
+func isJsonContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+type healthCheckResult struct {
+	Database string `json:"database"`
+	Gw2Api   string `json:"gw2Api"`
+	Gw2Build int    `json:"gw2Build,omitempty"`
+}
+
+// healthCheck verifies that both the database and the GW2 API are reachable without touching any data
+func healthCheck(ctx context.Context, db *dbConn, job JobExecutionRequest) (any, error) {
+	if err := job.decodeParams(&struct{}{}); err != nil {
+		return nil, err
+	}
+
+	result := healthCheckResult{Database: "OK", Gw2Api: "OK"}
+
+	var one int
+	conn, dbErr := db.get(ctx)
+	if dbErr == nil {
+		dbErr = conn.QueryRow(ctx, "SELECT 1").Scan(&one)
+	}
+
+	if dbErr != nil {
+		result.Database = dbErr.Error()
+	}
+
+	var build struct {
+		Id int `json:"id"`
+	}
+	gw2Err := gw2Get(ctx, "/v2/build", nil, "", &build)
+	if gw2Err != nil {
+		result.Gw2Api = gw2Err.Error()
+	} else {
+		result.Gw2Build = build.Id
+	}
+
+	return result, errors.Join(dbErr, gw2Err)
+}
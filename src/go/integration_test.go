@@ -0,0 +1,427 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cockroachImage is the CockroachDB version the integration tests run against
+const cockroachImage = "cockroachdb/cockroach:v23.1.11"
+
+// cockroachAddr is the host:port of the CockroachDB container shared by all integration tests
+var cockroachAddr string
+
+func TestMain(m *testing.M) {
+	os.Exit(runWithCockroach(m))
+}
+
+func runWithCockroach(m *testing.M) int {
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        cockroachImage,
+			Cmd:          []string{"start-single-node", "--insecure"},
+			ExposedPorts: []string{"26257/tcp", "8080/tcp"},
+			WaitingFor:   wait.ForHTTP("/health?ready=1").WithPort("8080/tcp").WithStartupTimeout(time.Minute * 2),
+		},
+		Started: true,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to start CockroachDB:", err)
+		return 1
+	}
+	defer container.Terminate(ctx)
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to get the CockroachDB host:", err)
+		return 1
+	}
+
+	port, err := container.MappedPort(ctx, "26257/tcp")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to get the CockroachDB port:", err)
+		return 1
+	}
+
+	cockroachAddr = host + ":" + port.Port()
+	return m.Run()
+}
+
+// newTestDb creates a database for the calling test containing the gw2auth.com tables used by the jobs
+// and the tables of src/sql/background_jobs.sql. It returns the connection string of that database.
+func newTestDb(t *testing.T) (*dbConn, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	dbName := "test_" + strings.ToLower(randomId())
+	admin, err := pgx.Connect(ctx, "postgres://root@"+cockroachAddr+"/defaultdb?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close(ctx)
+
+	if _, err = admin.Exec(ctx, "CREATE DATABASE "+dbName); err != nil {
+		t.Fatal(err)
+	}
+
+	connString := "postgres://root@" + cockroachAddr + "/" + dbName + "?sslmode=disable"
+	db := &dbConn{connString: connString}
+	t.Cleanup(db.close)
+
+	conn, err := db.get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"testdata/gw2auth_schema.sql", "../sql/background_jobs.sql"} {
+		schema, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = conn.Exec(ctx, string(schema)); err != nil {
+			t.Fatalf("failed to apply %s: %v", file, err)
+		}
+	}
+
+	return db, connString
+}
+
+func mustExec(t *testing.T, db *dbConn, sql string, args ...any) {
+	t.Helper()
+
+	conn, err := db.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = conn.Exec(context.Background(), sql, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustCount(t *testing.T, db *dbConn, sql string, args ...any) int {
+	t.Helper()
+
+	conn, err := db.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	if err = conn.QueryRow(context.Background(), sql, args...).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+func runTestJob(t *testing.T, db *dbConn, run jobFunc, name string, params string) any {
+	t.Helper()
+
+	job := JobExecutionRequest{Name: name}
+	if params != "" {
+		job.Params = json.RawMessage(params)
+	}
+
+	result, err := run(context.Background(), db, job)
+	if err != nil {
+		t.Fatalf("%s failed: %v", name, err)
+	}
+
+	return result
+}
+
+// fakeGw2Api serves the given handler as GW2 API for the duration of the test
+func fakeGw2Api(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	withGw2ApiBaseUrl(t, server.URL)
+}
+
+func writeGw2Json(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprint(w, body)
+}
+
+func TestValidateSchemaIntegration(t *testing.T) {
+	db, _ := newTestDb(t)
+
+	result := runTestJob(t, db, validateSchema, "VALIDATE_SCHEMA", "")
+	if missing := result.(validateSchemaResult).Missing; len(missing) > 0 {
+		t.Errorf("missing columns: %v", missing)
+	}
+}
+
+func TestDeleteExpiredSessionsInChunksIntegration(t *testing.T) {
+	db, _ := newTestDb(t)
+
+	// more than two chunks of expired sessions, plus sessions which did not expire yet
+	mustExec(t, db, "INSERT INTO account_federation_sessions (id, expiration_time) SELECT 'expired-' || i::STRING, now() - INTERVAL '1 hour' FROM generate_series(1, $1) AS i", deleteChunkSize*2+500)
+	mustExec(t, db, "INSERT INTO account_federation_sessions (id, expiration_time) SELECT 'valid-' || i::STRING, now() + INTERVAL '1 hour' FROM generate_series(1, 10) AS i")
+
+	result := runTestJob(t, db, deleteExpiredSessions, "DELETE_EXPIRED_SESSIONS", "")
+	if deleted := result.(deleteResult).Deleted; deleted != deleteChunkSize*2+500 {
+		t.Errorf("deleted %d sessions, want %d", deleted, deleteChunkSize*2+500)
+	}
+
+	if remaining := mustCount(t, db, "SELECT COUNT(*) FROM account_federation_sessions"); remaining != 10 {
+		t.Errorf("%d sessions remain, want 10", remaining)
+	}
+}
+
+func TestDeleteStaleTokensIntegration(t *testing.T) {
+	db, _ := newTestDb(t)
+	now := time.Now()
+	accountId := "00000000-0000-0000-0000-000000000001"
+
+	tokens := []struct {
+		gw2AccountId   string
+		lastValid      time.Time
+		lastValidCheck time.Time
+	}{
+		// invalid for 40 days: deleted
+		{gw2AccountId: "stale", lastValid: now.AddDate(0, 0, -40), lastValidCheck: now},
+		// valid at its last check 40 days ago, merely due for a check: kept
+		{gw2AccountId: "unchecked", lastValid: now.AddDate(0, 0, -40), lastValidCheck: now.AddDate(0, 0, -40)},
+		// invalid for 2 days only: kept
+		{gw2AccountId: "recently-invalid", lastValid: now.AddDate(0, 0, -2), lastValidCheck: now},
+		{gw2AccountId: "valid", lastValid: now, lastValidCheck: now},
+	}
+	for _, tk := range tokens {
+		mustExec(t, db, "INSERT INTO gw2_account_api_tokens (account_id, gw2_account_id, gw2_api_token, last_valid_time, last_valid_check_time) VALUES ($1, $2, 'token', $3, $4)", accountId, tk.gw2AccountId, tk.lastValid, tk.lastValidCheck)
+	}
+
+	result := runTestJob(t, db, deleteStaleTokens, "DELETE_STALE_TOKENS", "")
+	if deleted := result.(deleteResult).Deleted; deleted != 1 {
+		t.Errorf("deleted %d tokens, want 1", deleted)
+	}
+
+	if remaining := mustCount(t, db, "SELECT COUNT(*) FROM gw2_account_api_tokens WHERE gw2_account_id = 'stale'"); remaining != 0 {
+		t.Error("stale token was not deleted")
+	}
+}
+
+func TestDeleteOrphanedGw2AccountsIntegration(t *testing.T) {
+	db, _ := newTestDb(t)
+	old := time.Now().AddDate(0, 0, -7)
+	accountId := "00000000-0000-0000-0000-000000000001"
+
+	for _, acc := range []struct {
+		gw2AccountId string
+		creationTime time.Time
+	}{
+		{gw2AccountId: "orphaned", creationTime: old},
+		{gw2AccountId: "just-created", creationTime: time.Now()},
+		{gw2AccountId: "with-token", creationTime: old},
+		{gw2AccountId: "verified", creationTime: old},
+		{gw2AccountId: "authorized", creationTime: old},
+	} {
+		mustExec(t, db, "INSERT INTO gw2_accounts (account_id, gw2_account_id, gw2_account_name, creation_time, last_name_check_time) VALUES ($1, $2, 'Name.1234', $3, $3)", accountId, acc.gw2AccountId, acc.creationTime)
+	}
+
+	mustExec(t, db, "INSERT INTO gw2_account_api_tokens (account_id, gw2_account_id, gw2_api_token, last_valid_time, last_valid_check_time) VALUES ($1, 'with-token', 'token', now(), now())", accountId)
+	mustExec(t, db, "INSERT INTO gw2_account_verifications (account_id, gw2_account_id) VALUES ($1, 'verified')", accountId)
+	mustExec(t, db, "INSERT INTO client_authorization_gw2_accounts (client_authorization_id, account_id, gw2_account_id) VALUES (gen_random_uuid(), $1, 'authorized')", accountId)
+
+	result := runTestJob(t, db, deleteOrphanedGw2Accounts, "DELETE_ORPHANED_GW2_ACCOUNTS", "")
+	if deleted := result.(deleteResult).Deleted; deleted != 1 {
+		t.Errorf("deleted %d accounts, want 1", deleted)
+	}
+
+	if remaining := mustCount(t, db, "SELECT COUNT(*) FROM gw2_accounts WHERE gw2_account_id = 'orphaned'"); remaining != 0 {
+		t.Error("orphaned account was not deleted")
+	}
+
+	// running it again deletes nothing
+	if deleted := runTestJob(t, db, deleteOrphanedGw2Accounts, "DELETE_ORPHANED_GW2_ACCOUNTS", "").(deleteResult).Deleted; deleted != 0 {
+		t.Errorf("second run deleted %d accounts, want 0", deleted)
+	}
+}
+
+func TestAcquireJobLockIntegration(t *testing.T) {
+	db, _ := newTestDb(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	release, acquired, err := acquireJobLock(ctx, db, "TEST")
+	if err != nil || !acquired {
+		t.Fatalf("acquireJobLock() = %v, %v, want acquired", acquired, err)
+	}
+
+	if _, acquired, err = acquireJobLock(ctx, db, "TEST"); err != nil || acquired {
+		t.Fatalf("second acquireJobLock() = %v, %v, want not acquired", acquired, err)
+	}
+
+	release()
+
+	release, acquired, err = acquireJobLock(ctx, db, "TEST")
+	if err != nil || !acquired {
+		t.Fatalf("acquireJobLock() after release = %v, %v, want acquired", acquired, err)
+	}
+	release()
+}
+
+func insertSubtoken(t *testing.T, db *dbConn, gw2AccountId string, expiresAt time.Time) {
+	t.Helper()
+
+	accountId := "00000000-0000-0000-0000-000000000001"
+	mustExec(t, db, "INSERT INTO gw2_account_api_tokens (account_id, gw2_account_id, gw2_api_token, last_valid_time, last_valid_check_time) VALUES ($1, $2, $3, now(), now())", accountId, gw2AccountId, "token-"+gw2AccountId)
+	mustExec(t, db, "INSERT INTO gw2_api_subtokens (account_id, gw2_account_id, gw2_api_permissions_bit_set, gw2_api_subtoken, expiration_time) VALUES ($1, $2, 1, 'old', $3)", accountId, gw2AccountId, expiresAt)
+}
+
+func TestRefreshSubtokensIntegration(t *testing.T) {
+	db, _ := newTestDb(t)
+	insertSubtoken(t, db, "refreshed", time.Now().Add(time.Minute*10))
+	insertSubtoken(t, db, "missing-scope", time.Now().Add(time.Minute*10))
+	insertSubtoken(t, db, "bad-request", time.Now().Add(time.Minute*10))
+
+	fakeGw2Api(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer token-refreshed":
+			if r.URL.Query().Get("permissions") != "account" {
+				t.Errorf("unexpected permissions %q", r.URL.Query().Get("permissions"))
+			}
+			writeGw2Json(w, http.StatusOK, `{"subtoken":"new"}`)
+		case "Bearer token-missing-scope":
+			writeGw2Json(w, http.StatusForbidden, `{"text":"requires scope account"}`)
+		default:
+			writeGw2Json(w, http.StatusBadRequest, `{"text":"invalid permissions"}`)
+		}
+	})
+
+	result := runTestJob(t, db, refreshSubtokens, "REFRESH_SUBTOKENS", "").(refreshSubtokensResult)
+	if result.Refreshed != 1 || result.Removed != 1 || result.Failed != 1 {
+		t.Errorf("result = %+v, want 1 refreshed, 1 removed and 1 failed", result)
+	}
+
+	if n := mustCount(t, db, "SELECT COUNT(*) FROM gw2_api_subtokens WHERE gw2_account_id = 'refreshed' AND gw2_api_subtoken = 'new' AND last_refresh_attempt_time IS NOT NULL"); n != 1 {
+		t.Error("subtoken was not refreshed")
+	}
+
+	if n := mustCount(t, db, "SELECT COUNT(*) FROM gw2_api_subtokens WHERE gw2_account_id = 'missing-scope'"); n != 0 {
+		t.Error("subtoken whose parent is missing a scope was not removed")
+	}
+
+	// a bad request is kept, but moves behind the subtokens not tried yet
+	if n := mustCount(t, db, "SELECT COUNT(*) FROM gw2_api_subtokens WHERE gw2_account_id = 'bad-request' AND gw2_api_subtoken = 'old' AND last_refresh_attempt_time IS NOT NULL"); n != 1 {
+		t.Error("failed subtoken was not kept with its attempt recorded")
+	}
+}
+
+// TestRefreshSubtokensConcurrentUpdateIntegration stores a longer lived subtoken between loading and updating it,
+// like a concurrent run would, and verifies the guarded update keeps it
+func TestRefreshSubtokensConcurrentUpdateIntegration(t *testing.T) {
+	db, connString := newTestDb(t)
+	insertSubtoken(t, db, "concurrent", time.Now().Add(time.Minute*10))
+	concurrentExpiration := time.Now().Add(time.Hour * 48).Truncate(time.Second)
+
+	fakeGw2Api(t, func(w http.ResponseWriter, r *http.Request) {
+		other, err := pgx.Connect(r.Context(), connString)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer other.Close(context.Background())
+
+		if _, err = other.Exec(r.Context(), "UPDATE gw2_api_subtokens SET gw2_api_subtoken = 'concurrent', expiration_time = $1", concurrentExpiration); err != nil {
+			t.Error(err)
+		}
+
+		writeGw2Json(w, http.StatusOK, `{"subtoken":"new"}`)
+	})
+
+	result := runTestJob(t, db, refreshSubtokens, "REFRESH_SUBTOKENS", "").(refreshSubtokensResult)
+	if result.Refreshed != 0 || result.Skipped != 1 {
+		t.Errorf("result = %+v, want 0 refreshed and 1 skipped", result)
+	}
+
+	if n := mustCount(t, db, "SELECT COUNT(*) FROM gw2_api_subtokens WHERE gw2_api_subtoken = 'concurrent' AND expiration_time = $1", concurrentExpiration); n != 1 {
+		t.Error("concurrently stored subtoken was overwritten")
+	}
+}
+
+// TestReconcileAccountNamesConcurrentUpdateIntegration stores a different name between loading and updating it,
+// like a concurrent name check would, and verifies the guarded update keeps it
+func TestReconcileAccountNamesConcurrentUpdateIntegration(t *testing.T) {
+	db, connString := newTestDb(t)
+	accountId := "00000000-0000-0000-0000-000000000001"
+	old := time.Now().AddDate(0, 0, -7)
+	mustExec(t, db, "INSERT INTO gw2_accounts (account_id, gw2_account_id, gw2_account_name, creation_time, last_name_check_time) VALUES ($1, 'A1B2C3D4-0000-1111-2222-333344445555', 'Name', $2, $2)", accountId, old)
+	mustExec(t, db, "INSERT INTO gw2_account_api_tokens (account_id, gw2_account_id, gw2_api_token, last_valid_time, last_valid_check_time) VALUES ($1, 'A1B2C3D4-0000-1111-2222-333344445555', 'token', now(), now())", accountId)
+
+	fakeGw2Api(t, func(w http.ResponseWriter, r *http.Request) {
+		other, err := pgx.Connect(r.Context(), connString)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer other.Close(context.Background())
+
+		if _, err = other.Exec(r.Context(), "UPDATE gw2_accounts SET gw2_account_name = 'Other.5678'"); err != nil {
+			t.Error(err)
+		}
+
+		writeGw2Json(w, http.StatusOK, `{"id":"a1b2c3d4-0000-1111-2222-333344445555","name":"Name.1234"}`)
+	})
+
+	result := runTestJob(t, db, reconcileAccountNames, "RECONCILE_ACCOUNT_NAMES", "").(reconcileAccountNamesResult)
+	if result.Corrected != 0 || result.Skipped != 1 {
+		t.Errorf("result = %+v, want 0 corrected and 1 skipped", result)
+	}
+
+	if n := mustCount(t, db, "SELECT COUNT(*) FROM gw2_accounts WHERE gw2_account_name = 'Other.5678'"); n != 1 {
+		t.Error("concurrently stored name was overwritten")
+	}
+}
+
+func TestReconcileAccountNamesIntegration(t *testing.T) {
+	db, _ := newTestDb(t)
+	accountId := "00000000-0000-0000-0000-000000000001"
+	old := time.Now().AddDate(0, 0, -7)
+
+	for _, gw2AccountId := range []string{"ok", "failing", "invalid-token"} {
+		mustExec(t, db, "INSERT INTO gw2_accounts (account_id, gw2_account_id, gw2_account_name, creation_time, last_name_check_time) VALUES ($1, $2, 'Name', $3, $3)", accountId, gw2AccountId, old)
+	}
+
+	mustExec(t, db, "INSERT INTO gw2_account_api_tokens (account_id, gw2_account_id, gw2_api_token, last_valid_time, last_valid_check_time) VALUES ($1, 'ok', 'token-ok', now(), now())", accountId)
+	mustExec(t, db, "INSERT INTO gw2_account_api_tokens (account_id, gw2_account_id, gw2_api_token, last_valid_time, last_valid_check_time) VALUES ($1, 'failing', 'token-failing', now(), now())", accountId)
+	// invalid at its last check: never used
+	mustExec(t, db, "INSERT INTO gw2_account_api_tokens (account_id, gw2_account_id, gw2_api_token, last_valid_time, last_valid_check_time) VALUES ($1, 'invalid-token', 'token-invalid', now() - INTERVAL '1 day', now())", accountId)
+
+	fakeGw2Api(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer token-ok":
+			writeGw2Json(w, http.StatusOK, `{"id":"ok","name":"Name.1234"}`)
+		case "Bearer token-invalid":
+			t.Error("a token which was invalid at its last check was used")
+			writeGw2Json(w, http.StatusUnauthorized, `{"text":"invalid key"}`)
+		default:
+			writeGw2Json(w, http.StatusUnauthorized, `{"text":"invalid key"}`)
+		}
+	})
+
+	result := runTestJob(t, db, reconcileAccountNames, "RECONCILE_ACCOUNT_NAMES", "").(reconcileAccountNamesResult)
+	if result.Corrected != 1 || result.Failed != 1 {
+		t.Errorf("result = %+v, want 1 corrected and 1 failed", result)
+	}
+
+	// the failed account moves behind the ones not checked recently
+	if n := mustCount(t, db, "SELECT COUNT(*) FROM gw2_accounts WHERE gw2_account_id = 'failing' AND last_name_check_time > $1", old); n != 1 {
+		t.Error("last_name_check_time of the failed account was not bumped")
+	}
+}
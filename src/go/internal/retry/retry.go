@@ -0,0 +1,62 @@
+// Package retry implements retrying of operations with exponential backoff.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Policy describes how often and how fast an operation is retried
+type Policy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the second attempt. It doubles for every further attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts. Zero means no cap.
+	MaxDelay time.Duration
+	// Jitter is the fraction (0 to 1) by which every delay is randomly shortened, to spread out concurrent retries
+	Jitter float64
+}
+
+// Delay returns the delay before the given attempt (starting at 2 for the first retry), without jitter
+func (p Policy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 2; i < attempt; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+
+	return delay
+}
+
+// Do executes fn until it succeeds, isRetryable reports false for its error, or the policy's attempts are exhausted.
+// isRetryable is only consulted if another attempt is left, so it may also be used to log the upcoming retry.
+// If ctx is done while waiting for the next attempt, the last error of fn is returned.
+func Do(ctx context.Context, policy Policy, fn func() error, isRetryable func(err error) bool) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
+
+		delay := policy.Delay(attempt + 1)
+		if policy.Jitter > 0 {
+			delay -= time.Duration(rand.Float64() * policy.Jitter * float64(delay))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var errTest = errors.New("test error")
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{BaseDelay: time.Millisecond * 100, MaxDelay: time.Millisecond * 500}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 2, want: time.Millisecond * 100},
+		{attempt: 3, want: time.Millisecond * 200},
+		{attempt: 4, want: time.Millisecond * 400},
+		{attempt: 5, want: time.Millisecond * 500},
+		{attempt: 50, want: time.Millisecond * 500},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Delay(tt.attempt); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyDelayWithoutCap(t *testing.T) {
+	policy := Policy{BaseDelay: time.Second}
+	if got, want := policy.Delay(12), time.Second*1024; got != want {
+		t.Errorf("Delay(12) = %v, want %v", got, want)
+	}
+}
+
+func TestPolicyDelayBaseAboveCap(t *testing.T) {
+	policy := Policy{BaseDelay: time.Second * 10, MaxDelay: time.Second}
+	if got, want := policy.Delay(2), time.Second; got != want {
+		t.Errorf("Delay(2) = %v, want %v", got, want)
+	}
+}
+
+func TestDoSucceedsAfterRetries(t *testing.T) {
+	calls := 0
+	err := Do(context.Background(), Policy{MaxAttempts: 3}, func() error {
+		calls++
+		if calls < 3 {
+			return errTest
+		}
+
+		return nil
+	}, func(error) bool { return true })
+
+	if err != nil {
+		t.Fatalf("Do() = %v, want nil", err)
+	}
+
+	if calls != 3 {
+		t.Errorf("fn called %d times, want 3", calls)
+	}
+}
+
+func TestDoStopsAtMaxAttempts(t *testing.T) {
+	calls, retryableCalls := 0, 0
+	err := Do(context.Background(), Policy{MaxAttempts: 4}, func() error {
+		calls++
+		return errTest
+	}, func(error) bool {
+		retryableCalls++
+		return true
+	})
+
+	if !errors.Is(err, errTest) {
+		t.Fatalf("Do() = %v, want %v", err, errTest)
+	}
+
+	if calls != 4 {
+		t.Errorf("fn called %d times, want 4", calls)
+	}
+
+	// isRetryable is only consulted if another attempt is left
+	if retryableCalls != 3 {
+		t.Errorf("isRetryable called %d times, want 3", retryableCalls)
+	}
+}
+
+func TestDoStopsOnNonRetryableError(t *testing.T) {
+	calls := 0
+	err := Do(context.Background(), Policy{MaxAttempts: 5}, func() error {
+		calls++
+		return errTest
+	}, func(error) bool { return false })
+
+	if !errors.Is(err, errTest) {
+		t.Fatalf("Do() = %v, want %v", err, errTest)
+	}
+
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+}
+
+func TestDoReturnsLastErrorWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var errs []error
+	err := Do(ctx, Policy{MaxAttempts: 5}, func() error {
+		errs = append(errs, fmt.Errorf("attempt %d", len(errs)+1))
+		if len(errs) == 2 {
+			cancel()
+		}
+
+		return errs[len(errs)-1]
+	}, func(error) bool { return true })
+
+	if len(errs) != 2 {
+		t.Fatalf("fn called %d times, want 2", len(errs))
+	}
+
+	if err != errs[1] {
+		t.Errorf("Do() = %v, want %v", err, errs[1])
+	}
+}
+
+func TestDoReturnsWhenContextDoneWhileWaiting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	start := time.Now()
+	err := Do(ctx, Policy{MaxAttempts: 2, BaseDelay: time.Hour}, func() error { return errTest }, func(error) bool { return true })
+
+	if !errors.Is(err, errTest) {
+		t.Fatalf("Do() = %v, want %v", err, errTest)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do() returned after %v, want it to return once the context is done", elapsed)
+	}
+}
+
+func TestDoDoesNotRetryWhenContextAlreadyDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := Do(ctx, Policy{MaxAttempts: 5}, func() error {
+		calls++
+		return errTest
+	}, func(error) bool { return true })
+
+	if !errors.Is(err, errTest) {
+		t.Fatalf("Do() = %v, want %v", err, errTest)
+	}
+
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+}
+
+func TestDoJitterOnlyShortensDelay(t *testing.T) {
+	policy := Policy{MaxAttempts: 2, BaseDelay: time.Millisecond * 40, Jitter: 0.5}
+
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		_ = Do(context.Background(), policy, func() error { return errTest }, func(error) bool { return true })
+		elapsed := time.Since(start)
+
+		// the delay is shortened by at most Jitter*delay and never extended; allow slack for scheduling
+		if min := time.Millisecond * 20; elapsed < min {
+			t.Errorf("elapsed %v, want at least %v", elapsed, min)
+		}
+
+		if max := policy.BaseDelay + time.Millisecond*200; elapsed > max {
+			t.Errorf("elapsed %v, want at most %v", elapsed, max)
+		}
+	}
+}
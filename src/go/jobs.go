@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log/slog"
+	"time"
+)
+
+const (
+	// deleteChunkSize is the maximum number of rows removed by a single chunked DELETE statement
+	deleteChunkSize = 1000
+	// deleteDeadlineMargin is the remaining time below which no further chunk is started
+	deleteDeadlineMargin = time.Second * 10
+	// defaultStaleTokenAge is the default time since a token was last valid after which it is deleted
+	defaultStaleTokenAge = time.Hour * 24 * 30
+	// minStaleTokenAge protects against accidentally deleting tokens which are only temporarily invalid
+	minStaleTokenAge = time.Hour * 24
+	// defaultOrphanGracePeriod is the default minimum age of a gw2_accounts row before it may be deleted as orphaned
+	defaultOrphanGracePeriod = time.Hour * 24
+	// minOrphanGracePeriod protects rows which are still being set up, e.g. inserted before their token
+	minOrphanGracePeriod = time.Hour
+	// defaultJobTimeout applies to jobs which neither define their own timeout nor got one in the request
+	defaultJobTimeout = time.Minute * 2
+)
+
+type deleteExpiredParams struct {
+	// OlderThan only deletes rows which expired at least this long ago
+	OlderThan Duration `json:"olderThan"`
+}
+
+type deleteStaleTokensParams struct {
+	// OlderThan deletes tokens which were last valid at least this long ago
+	OlderThan Duration `json:"olderThan"`
+}
+
+type deleteOrphanedGw2AccountsParams struct {
+	// OlderThan only deletes rows which were created at least this long ago
+	OlderThan Duration `json:"olderThan"`
+}
+
+// deleteResult is the result of all jobs which delete rows
+type deleteResult struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// jobFunc executes a single job. The returned result is persisted as JSON in the job_runs table.
+type jobFunc func(ctx context.Context, db *dbConn, job JobExecutionRequest) (any, error)
+
+type jobDefinition struct {
+	run jobFunc
+	// stateless jobs neither take the job lock nor are recorded in job_runs
+	stateless bool
+	// timeout overrides defaultJobTimeout for this job
+	timeout time.Duration
+	// usesGw2Api jobs are skipped during the configured GW2 API quiet windows
+	usesGw2Api bool
+}
+
+var jobs = map[string]jobDefinition{
+	"DELETE_EXPIRED_SESSIONS":       {run: deleteExpiredSessions},
+	"DELETE_EXPIRED_AUTHORIZATIONS": {run: deleteExpiredAuthorizations},
+	"DELETE_STALE_TOKENS":           {run: deleteStaleTokens},
+	"DELETE_ORPHANED_GW2_ACCOUNTS":  {run: deleteOrphanedGw2Accounts},
+	"HEALTH_CHECK":                  {run: healthCheck, stateless: true, timeout: time.Second * 30},
+	"VALIDATE_SCHEMA":               {run: validateSchema, stateless: true, timeout: time.Second * 30},
+	"REFRESH_SUBTOKENS":             {run: refreshSubtokens, usesGw2Api: true},
+	"RECONCILE_ACCOUNT_NAMES":       {run: reconcileAccountNames, usesGw2Api: true},
+	"REFRESH_ACCOUNT_COUNTS":        {run: refreshAccountCounts},
+}
+
+func executeJob(ctx context.Context, db *dbConn, job JobExecutionRequest) (JobExecutionResult, error) {
+	execResult := JobExecutionResult{Name: job.Name, Status: jobStatusSkipped}
+
+	def, ok := jobs[job.Name]
+	if !ok {
+		return execResult, errors.New("unknown job: " + job.Name)
+	}
+
+	if def.usesGw2Api {
+		if quiet, err := inGw2QuietWindow(time.Now()); err != nil {
+			return execResult, err
+		} else if quiet {
+			slog.InfoContext(ctx, "GW2 API quiet window, skipping")
+			return execResult, nil
+		}
+	}
+
+	conn, err := db.get(ctx)
+	if err != nil {
+		return execResult, err
+	}
+
+	if def.stateless {
+		result, err := def.run(ctx, db, job)
+		return jobExecutionResult(ctx, job, result, err), err
+	}
+
+	release, acquired, err := acquireJobLock(ctx, db, job.Name)
+	if err != nil {
+		return execResult, err
+	} else if !acquired {
+		slog.InfoContext(ctx, "another run of this job is in progress, skipping")
+		return execResult, nil
+	}
+	defer release()
+
+	if job.Cooldown > 0 {
+		lastRun, err := lastSuccessfulJobRun(ctx, conn, job.Name)
+		if err != nil {
+			return execResult, err
+		}
+
+		if !lastRun.IsZero() && time.Since(lastRun) < time.Duration(job.Cooldown) {
+			slog.InfoContext(ctx, "job ran within its cooldown, skipping", slog.Time("lastRun", lastRun))
+			return execResult, nil
+		}
+	}
+
+	startedAt := time.Now()
+	result, err := def.run(ctx, db, job)
+	// the job might have left a closed connection behind, so the run is recorded through db
+	recordJobRun(ctx, db, job.Name, startedAt, result, err)
+	if err != nil {
+		recordJobFailure(ctx, db, job, err)
+	}
+
+	return jobExecutionResult(ctx, job, result, err), err
+}
+
+func jobExecutionResult(ctx context.Context, job JobExecutionRequest, result any, err error) JobExecutionResult {
+	return JobExecutionResult{Name: job.Name, Status: jobStatus(ctx, err), Result: result}
+}
+
+// jobStatus derives the status of a finished job from its context and error
+func jobStatus(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return jobStatusSuccess
+	case errors.Is(err, context.DeadlineExceeded) && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return jobStatusTimeout
+	default:
+		return jobStatusFailure
+	}
+}
+
+func deleteExpiredSessions(ctx context.Context, db *dbConn, job JobExecutionRequest) (any, error) {
+	var params deleteExpiredParams
+	if err := job.decodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	deleted, err := deleteInChunks(ctx, db, "DELETE FROM account_federation_sessions WHERE expiration_time <= $1 LIMIT $2", time.Now().Add(-time.Duration(params.OlderThan)))
+	return deleteResult{Deleted: deleted}, err
+}
+
+func deleteExpiredAuthorizations(ctx context.Context, db *dbConn, job JobExecutionRequest) (any, error) {
+	var params deleteExpiredParams
+	if err := job.decodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	deleted, err := deleteInChunks(ctx, db, "DELETE FROM client_authorizations WHERE COALESCE(GREATEST(authorization_code_expires_at, access_token_expires_at, refresh_token_expires_at), (last_update_time + INTERVAL '1 DAY')) <= $1 LIMIT $2", time.Now().Add(-time.Duration(params.OlderThan)))
+	return deleteResult{Deleted: deleted}, err
+}
+
+// deleteStaleTokens deletes tokens which have been invalid for a long time.
+// Only tokens whose most recent check failed are considered, so tokens which are merely due for a check are kept.
+func deleteStaleTokens(ctx context.Context, db *dbConn, job JobExecutionRequest) (any, error) {
+	params := deleteStaleTokensParams{OlderThan: Duration(defaultStaleTokenAge)}
+	if err := job.decodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	if time.Duration(params.OlderThan) < minStaleTokenAge {
+		return nil, fmt.Errorf("olderThan must be at least %v", minStaleTokenAge)
+	}
+
+	deleted, err := deleteInChunks(ctx, db, "DELETE FROM gw2_account_api_tokens WHERE last_valid_time <= $1 AND last_valid_check_time > last_valid_time LIMIT $2", time.Now().Add(-time.Duration(params.OlderThan)))
+	slog.InfoContext(ctx, "deleted stale tokens", slog.Int64("deleted", deleted))
+
+	return deleteResult{Deleted: deleted}, err
+}
+
+// deleteOrphanedGw2Accounts deletes gw2_accounts rows which have no API token left.
+// The foreign keys referencing gw2_accounts (account_id, gw2_account_id) are checked before deleting:
+// gw2_account_api_tokens, gw2_account_verifications and client_authorization_gw2_accounts.
+// Rows younger than the grace period are kept, since the app may insert them before their token.
+func deleteOrphanedGw2Accounts(ctx context.Context, db *dbConn, job JobExecutionRequest) (any, error) {
+	params := deleteOrphanedGw2AccountsParams{OlderThan: Duration(defaultOrphanGracePeriod)}
+	if err := job.decodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	if time.Duration(params.OlderThan) < minOrphanGracePeriod {
+		return nil, fmt.Errorf("olderThan must be at least %v", minOrphanGracePeriod)
+	}
+
+	deleted, err := deleteInChunks(
+		ctx,
+		db,
+		`
+DELETE FROM gw2_accounts acc
+WHERE acc.creation_time <= $1
+AND NOT EXISTS(SELECT 1 FROM gw2_account_api_tokens tk WHERE tk.account_id = acc.account_id AND tk.gw2_account_id = acc.gw2_account_id)
+AND NOT EXISTS(SELECT 1 FROM gw2_account_verifications ver WHERE ver.account_id = acc.account_id AND ver.gw2_account_id = acc.gw2_account_id)
+AND NOT EXISTS(SELECT 1 FROM client_authorization_gw2_accounts auth WHERE auth.account_id = acc.account_id AND auth.gw2_account_id = acc.gw2_account_id)
+LIMIT $2
+`,
+		time.Now().Add(-time.Duration(params.OlderThan)),
+	)
+	slog.InfoContext(ctx, "deleted orphaned gw2 accounts", slog.Int64("deleted", deleted))
+
+	return deleteResult{Deleted: deleted}, err
+}
+
+// deleteInChunks repeatedly executes the given DELETE statement until it affects less than deleteChunkSize rows.
+// The statement must accept the chunk size as its last parameter. Every chunk is committed on its own,
+// so the job can be stopped between chunks without losing progress. Transient errors are retried per chunk.
+// No further chunk is started once the context deadline is closer than deleteDeadlineMargin.
+func deleteInChunks(ctx context.Context, db *dbConn, sql string, args ...any) (int64, error) {
+	args = append(args, deleteChunkSize)
+	total := int64(0)
+
+	for {
+		var tag pgconn.CommandTag
+		err := retryDb(ctx, db, func(conn *pgx.Conn) error {
+			var err error
+			tag, err = conn.Exec(ctx, sql, args...)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+
+		total += tag.RowsAffected()
+		slog.InfoContext(ctx, "deleted chunk", slog.Int64("deleted", tag.RowsAffected()), slog.Int64("total", total))
+
+		if tag.RowsAffected() < deleteChunkSize {
+			return total, nil
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < deleteDeadlineMargin {
+			slog.WarnContext(ctx, "stopping early because the deadline is near", slog.Int64("total", total))
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"log/slog"
+	"time"
+)
+
+// defaultJobLockLease is used as the lock lease when the context carries no deadline
+const defaultJobLockLease = time.Minute * 15
+
+// acquireJobLock tries to take the lock row of the given job. The lock is leased until the context deadline,
+// so a lock of a crashed invocation expires on its own. If another invocation holds the lock, acquired is false.
+// The returned release func must be called once the job finished.
+func acquireJobLock(ctx context.Context, db *dbConn, jobName string) (release func(), acquired bool, err error) {
+	conn, err := db.get(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	owner := lockOwner(ctx)
+	now := time.Now()
+	lockedUntil := now.Add(defaultJobLockLease)
+	if deadline, ok := ctx.Deadline(); ok {
+		lockedUntil = deadline
+	}
+
+	tag, err := conn.Exec(
+		ctx,
+		`
+INSERT INTO background_job_locks (job_name, owner, locked_until)
+VALUES ($1, $2, $3)
+ON CONFLICT (job_name) DO UPDATE
+SET owner = excluded.owner, locked_until = excluded.locked_until
+WHERE background_job_locks.locked_until <= $4
+`,
+		jobName,
+		owner,
+		lockedUntil,
+		now,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if tag.RowsAffected() < 1 {
+		return nil, false, nil
+	}
+
+	release = func() {
+		// the job context might already be done at this point
+		releaseCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		conn, err := db.get(releaseCtx)
+		if err == nil {
+			_, err = conn.Exec(releaseCtx, "DELETE FROM background_job_locks WHERE job_name = $1 AND owner = $2", jobName, owner)
+		}
+
+		if err != nil {
+			slog.WarnContext(ctx, "failed to release job lock", slog.String("err", err.Error()))
+		}
+	}
+
+	return release, true, nil
+}
+
+func lockOwner(ctx context.Context) string {
+	if lc, ok := lambdacontext.FromContext(ctx); ok && lc.AwsRequestID != "" {
+		return lc.AwsRequestID
+	}
+
+	return randomId()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+)
+
+type logAttrsKey struct{}
+
+// logLevel reads the minimum log level from GW2AUTH_LOG_LEVEL (DEBUG, INFO, WARN, ERROR), defaulting to INFO.
+// An invalid value also falls back to INFO; the returned error names the rejected value.
+func logLevel() (slog.Level, error) {
+	var level slog.Level
+	if v := os.Getenv("GW2AUTH_LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			return slog.LevelInfo, fmt.Errorf("invalid GW2AUTH_LOG_LEVEL %q, using INFO: %w", v, err)
+		}
+	}
+
+	return level, nil
+}
+
+// withLogAttrs returns a context whose log records carry the given attributes in addition to those of the parent context.
+// It only has an effect on loggers using a contextHandler.
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return context.WithValue(ctx, logAttrsKey{}, append(slices.Clip(existing), attrs...))
+}
+
+// contextHandler adds the attributes registered using withLogAttrs to every record logged with that context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const defaultReconcileNamesLimit = 50
+
+// gw2AccountNamePattern matches well-formed GW2 account names like "Name.1234"
+var gw2AccountNamePattern = regexp.MustCompile(`^.+\.[0-9]{4}$`)
+
+type reconcileAccountNamesParams struct {
+	// Limit is the maximum number of accounts reconciled per run
+	Limit int `json:"limit"`
+}
+
+type reconcileAccountNamesResult struct {
+	Corrected int `json:"corrected"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+}
+
+type malformedAccountName struct {
+	accountId    string
+	gw2AccountId string
+	name         string
+	token        string
+}
+
+// reconcileAccountNames fetches the current name of accounts whose stored name is malformed (missing the
+// numeric discriminator) and corrects it, independent of the regular name check schedule.
+// Failed attempts also bump last_name_check_time, so accounts which keep failing don't block the others.
+func reconcileAccountNames(ctx context.Context, db *dbConn, job JobExecutionRequest) (any, error) {
+	params := reconcileAccountNamesParams{Limit: defaultReconcileNamesLimit}
+	if err := job.decodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	if params.Limit < 1 {
+		return nil, errors.New("limit must be positive")
+	}
+
+	conn, err := db.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := loadMalformedAccountNames(ctx, conn, params.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var result reconcileAccountNamesResult
+	for _, acc := range accounts {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		name, err := fetchGw2AccountName(ctx, acc.token, acc.gw2AccountId)
+		if err == nil && !gw2AccountNamePattern.MatchString(name) {
+			err = fmt.Errorf("GW2 API returned a malformed name: %q", name)
+		}
+
+		if err != nil {
+			slog.WarnContext(ctx, "failed to reconcile account name", slog.String("account_id", acc.accountId), slog.String("gw2_account_id", acc.gw2AccountId), slog.String("err", err.Error()))
+			result.Failed++
+
+			_, err = conn.Exec(ctx, "UPDATE gw2_accounts SET last_name_check_time = $3 WHERE account_id = $1 AND gw2_account_id = $2", acc.accountId, acc.gw2AccountId, time.Now())
+			if err != nil {
+				return result, err
+			}
+
+			continue
+		}
+
+		// only update if the name is still the malformed one, so a name stored concurrently by someone else is kept
+		tag, err := conn.Exec(
+			ctx,
+			"UPDATE gw2_accounts SET gw2_account_name = $3, last_name_check_time = $4 WHERE account_id = $1 AND gw2_account_id = $2 AND gw2_account_name = $5",
+			acc.accountId,
+			acc.gw2AccountId,
+			name,
+			time.Now(),
+			acc.name,
+		)
+		if err != nil {
+			return result, err
+		} else if tag.RowsAffected() < 1 {
+			slog.InfoContext(ctx, "account name was changed concurrently, skipping", slog.String("account_id", acc.accountId), slog.String("gw2_account_id", acc.gw2AccountId))
+			result.Skipped++
+			continue
+		}
+
+		slog.InfoContext(ctx, "corrected account name", slog.String("account_id", acc.accountId), slog.String("gw2_account_id", acc.gw2AccountId), slog.String("old", acc.name), slog.String("new", name))
+		result.Corrected++
+	}
+
+	return result, nil
+}
+
+func loadMalformedAccountNames(ctx context.Context, conn *pgx.Conn, limit int) ([]malformedAccountName, error) {
+	rows, err := conn.Query(
+		ctx,
+		`
+SELECT acc.account_id, acc.gw2_account_id, acc.gw2_account_name, tk.gw2_api_token
+FROM gw2_accounts acc
+INNER JOIN gw2_account_api_tokens tk
+ON acc.account_id = tk.account_id AND acc.gw2_account_id = tk.gw2_account_id
+WHERE acc.gw2_account_name !~ $1
+AND tk.last_valid_time >= tk.last_valid_check_time
+ORDER BY acc.last_name_check_time ASC NULLS FIRST
+LIMIT $2
+`,
+		gw2AccountNamePattern.String(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make([]malformedAccountName, 0)
+	for rows.Next() {
+		var acc malformedAccountName
+		if err = rows.Scan(&acc.accountId, &acc.gw2AccountId, &acc.name, &acc.token); err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, acc)
+	}
+
+	return accounts, rows.Err()
+}
+
+// fetchGw2AccountName returns the name of the GW2 account the token belongs to.
+// If the token belongs to another account than gw2AccountId, ErrAccountMismatch is returned.
+func fetchGw2AccountName(ctx context.Context, token string, gw2AccountId string) (string, error) {
+	var account struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := gw2Get(ctx, "/v2/account", nil, token, &account); err != nil {
+		return "", err
+	}
+
+	// the GW2 API returns upper-case ids while UUID columns are read as lower-case
+	if !strings.EqualFold(account.Id, gw2AccountId) {
+		return "", ErrAccountMismatch
+	}
+
+	return account.Name, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchGw2AccountName(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"id":"A1B2C3D4-0000-1111-2222-333344445555","name":"Name.1234"}`)
+	}))
+	defer server.Close()
+
+	withGw2ApiBaseUrl(t, server.URL)
+
+	tests := []struct {
+		gw2AccountId string
+		wantErr      error
+	}{
+		{gw2AccountId: "A1B2C3D4-0000-1111-2222-333344445555"},
+		{gw2AccountId: "a1b2c3d4-0000-1111-2222-333344445555"},
+		{gw2AccountId: "a1b2c3d4-0000-1111-2222-999999999999", wantErr: ErrAccountMismatch},
+	}
+
+	for _, tt := range tests {
+		name, err := fetchGw2AccountName(context.Background(), "token", tt.gw2AccountId)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("fetchGw2AccountName(%q) error = %v, want %v", tt.gw2AccountId, err, tt.wantErr)
+		} else if tt.wantErr == nil && name != "Name.1234" {
+			t.Errorf("fetchGw2AccountName(%q) = %q, want %q", tt.gw2AccountId, name, "Name.1234")
+		}
+	}
+}
+
+// withGw2ApiBaseUrl points all GW2 API calls at the given URL until the test finished
+func withGw2ApiBaseUrl(t *testing.T, url string) {
+	previous := gw2ApiBaseUrl
+	gw2ApiBaseUrl = url
+	t.Cleanup(func() {
+		gw2ApiBaseUrl = previous
+	})
+}
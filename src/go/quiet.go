@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// quietWindow is a daily (or weekly, if weekday is set) UTC time range during which no GW2 API calls are made
+type quietWindow struct {
+	weekday *time.Weekday
+	start   time.Duration
+	end     time.Duration
+}
+
+func (w quietWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	sinceMidnight := t.Sub(t.Truncate(time.Hour * 24))
+
+	if w.start <= w.end {
+		return (w.weekday == nil || *w.weekday == t.Weekday()) && sinceMidnight >= w.start && sinceMidnight < w.end
+	}
+
+	// the window spans midnight: the part after midnight belongs to the following weekday
+	if sinceMidnight >= w.start {
+		return w.weekday == nil || *w.weekday == t.Weekday()
+	} else if sinceMidnight < w.end {
+		return w.weekday == nil || (*w.weekday+1)%7 == t.Weekday()
+	}
+
+	return false
+}
+
+// inGw2QuietWindow reports whether t falls into one of the windows configured in GW2AUTH_GW2_QUIET_WINDOWS.
+// The variable holds a comma separated list of UTC ranges, each optionally prefixed by a weekday,
+// e.g. "Tue 16:00-18:00,23:30-00:30".
+func inGw2QuietWindow(t time.Time) (bool, error) {
+	windows, err := parseQuietWindows(os.Getenv("GW2AUTH_GW2_QUIET_WINDOWS"))
+	if err != nil {
+		return false, err
+	}
+
+	for _, w := range windows {
+		if w.contains(t) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func parseQuietWindows(s string) ([]quietWindow, error) {
+	windows := make([]quietWindow, 0)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		w, err := parseQuietWindow(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet window %q: %w", part, err)
+		}
+
+		windows = append(windows, w)
+	}
+
+	return windows, nil
+}
+
+func parseQuietWindow(s string) (quietWindow, error) {
+	var w quietWindow
+	if day, timeRange, ok := strings.Cut(s, " "); ok {
+		weekday, err := parseWeekday(day)
+		if err != nil {
+			return w, err
+		}
+
+		w.weekday = &weekday
+		s = strings.TrimSpace(timeRange)
+	}
+
+	startStr, endStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return w, fmt.Errorf("expected a range like 16:00-18:00")
+	}
+
+	start, err := time.Parse("15:04", startStr)
+	if err != nil {
+		return w, err
+	}
+
+	end, err := time.Parse("15:04", endStr)
+	if err != nil {
+		return w, err
+	}
+
+	w.start = time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute
+	w.end = time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute
+	return w, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()[:3]) || strings.EqualFold(s, d.String()) {
+			return d, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown weekday %q", s)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietWindowContains(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		// 2026-10-12 is a Monday
+		return time.Date(2026, time.October, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		// daily window
+		{window: "16:00-18:00", t: at(12, 16, 0), want: true},
+		{window: "16:00-18:00", t: at(15, 17, 59), want: true},
+		{window: "16:00-18:00", t: at(12, 15, 59), want: false},
+		{window: "16:00-18:00", t: at(12, 18, 0), want: false},
+		// weekly window
+		{window: "Tue 16:00-18:00", t: at(13, 17, 0), want: true},
+		{window: "Tue 16:00-18:00", t: at(12, 17, 0), want: false},
+		{window: "Tue 16:00-18:00", t: at(14, 17, 0), want: false},
+		{window: "tuesday 16:00-18:00", t: at(13, 16, 0), want: true},
+		// daily window spanning midnight
+		{window: "23:30-00:30", t: at(12, 23, 30), want: true},
+		{window: "23:30-00:30", t: at(13, 0, 29), want: true},
+		{window: "23:30-00:30", t: at(12, 0, 30), want: false},
+		{window: "23:30-00:30", t: at(12, 23, 29), want: false},
+		{window: "23:30-00:30", t: at(12, 12, 0), want: false},
+		// weekly window spanning midnight: the part after midnight belongs to the following day
+		{window: "Fri 23:30-00:30", t: at(16, 23, 45), want: true},
+		{window: "Fri 23:30-00:30", t: at(17, 0, 15), want: true},
+		{window: "Fri 23:30-00:30", t: at(16, 0, 15), want: false},
+		{window: "Fri 23:30-00:30", t: at(17, 23, 45), want: false},
+		// Saturday wraps around to Sunday
+		{window: "Sat 23:00-01:00", t: at(18, 0, 30), want: true},
+		{window: "Sat 23:00-01:00", t: at(12, 0, 30), want: false},
+		// empty window
+		{window: "16:00-16:00", t: at(12, 16, 0), want: false},
+		// times are compared in UTC
+		{window: "16:00-18:00", t: at(12, 17, 0).In(time.FixedZone("UTC+2", 2*60*60)), want: true},
+	}
+
+	for _, tt := range tests {
+		w, err := parseQuietWindow(tt.window)
+		if err != nil {
+			t.Fatalf("parseQuietWindow(%q) = %v", tt.window, err)
+		}
+
+		if got := w.contains(tt.t); got != tt.want {
+			t.Errorf("%q contains %v = %v, want %v", tt.window, tt.t.Format(time.RFC1123), got, tt.want)
+		}
+	}
+}
+
+func TestParseQuietWindowsErrors(t *testing.T) {
+	for _, s := range []string{
+		"16:00",
+		"16:00-",
+		"16:00-24:00",
+		"4pm-6pm",
+		"Tus 16:00-18:00",
+		"16:00-18:00,Tue",
+	} {
+		if _, err := parseQuietWindows(s); err == nil {
+			t.Errorf("parseQuietWindows(%q) = nil error, want error", s)
+		}
+	}
+}
+
+func TestParseQuietWindows(t *testing.T) {
+	windows, err := parseQuietWindows(" Tue 16:00-18:00, ,23:30-00:30 ")
+	if err != nil {
+		t.Fatalf("parseQuietWindows() = %v", err)
+	}
+
+	if len(windows) != 2 {
+		t.Fatalf("parseQuietWindows() returned %d windows, want 2", len(windows))
+	}
+
+	if w := windows[0]; w.weekday == nil || *w.weekday != time.Tuesday || w.start != time.Hour*16 || w.end != time.Hour*18 {
+		t.Errorf("first window = %+v, want Tue 16:00-18:00", w)
+	}
+
+	if w := windows[1]; w.weekday != nil || w.start != time.Hour*23+time.Minute*30 || w.end != time.Minute*30 {
+		t.Errorf("second window = %+v, want 23:30-00:30", w)
+	}
+
+	if windows, err = parseQuietWindows(""); err != nil || len(windows) != 0 {
+		t.Errorf("parseQuietWindows(\"\") = %v, %v, want no windows", windows, err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gw2auth/background-jobs/internal/retry"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var dbRetryPolicy = retry.Policy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond * 200,
+	MaxDelay:    time.Second * 2,
+	Jitter:      0.2,
+}
+
+// isRetryableDbError reports whether err is a transient database error after which the statement can be executed
+// again. This covers serialization failures and deadlocks (SQLSTATE class 40), errors which occurred before anything
+// was sent to the server, and network errors like connection resets. Everything else (auth, syntax, constraint
+// violations) is permanent.
+func isRetryableDbError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "40")
+	}
+
+	var netErr net.Error
+	return pgconn.SafeToRetry(err) || errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// retryDb executes fn until it succeeds, fails with a permanent error, or dbRetryPolicy is exhausted.
+// Every attempt gets its connection from db, so an attempt following a connection reset runs on a new connection.
+// Since a reset can happen after the server executed the statement, fn must be safe to execute more than once.
+func retryDb(ctx context.Context, db *dbConn, fn func(conn *pgx.Conn) error) error {
+	attempt := func() error {
+		conn, err := db.get(ctx)
+		if err != nil {
+			return err
+		}
+
+		return fn(conn)
+	}
+
+	return retry.Do(ctx, dbRetryPolicy, attempt, func(err error) bool {
+		if !isRetryableDbError(err) {
+			return false
+		}
+
+		slog.WarnContext(ctx, "retrying after transient database error", slog.String("err", err.Error()))
+		return true
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+	"time"
+)
+
+const (
+	jobStatusSuccess = "SUCCESS"
+	jobStatusFailure = "FAILURE"
+	jobStatusSkipped = "SKIPPED"
+	jobStatusTimeout = "TIMEOUT"
+)
+
+// recordJobRun persists a finished job execution in the job_runs table.
+// Failing to do so is only logged, it must never change the outcome of the job itself.
+func recordJobRun(ctx context.Context, db *dbConn, jobName string, startedAt time.Time, result any, jobErr error) {
+	status := jobStatus(ctx, jobErr)
+	if jobErr != nil {
+		result = map[string]any{"error": jobErr.Error(), "result": result}
+	}
+
+	resultJson, err := json.Marshal(result)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to marshal job result", slog.String("err", err.Error()))
+		resultJson = []byte("null")
+	}
+
+	// the job context might already be done at this point
+	recordCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := db.get(recordCtx)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to record job run", slog.String("err", err.Error()))
+		return
+	}
+
+	_, err = conn.Exec(
+		recordCtx,
+		"INSERT INTO job_runs (job_name, started_at, finished_at, status, result) VALUES ($1, $2, $3, $4, $5)",
+		jobName,
+		startedAt,
+		time.Now(),
+		status,
+		string(resultJson),
+	)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to record job run", slog.String("err", err.Error()))
+	}
+}
+
+// recordJobFailure persists a failed job execution in the job_failures table so it can be re-driven later.
+// The attempt count is the number of failures of this job since it last succeeded, including this one.
+// Failing to record is only logged, it must never mask the original error.
+func recordJobFailure(ctx context.Context, db *dbConn, job JobExecutionRequest, jobErr error) {
+	var params *string
+	if len(job.Params) > 0 {
+		v := string(job.Params)
+		params = &v
+	}
+
+	// the job context might already be done at this point
+	recordCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := db.get(recordCtx)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to record job failure", slog.String("err", err.Error()))
+		return
+	}
+
+	_, err = conn.Exec(
+		recordCtx,
+		`
+INSERT INTO job_failures (job_name, params, error, failed_at, attempt)
+SELECT $1, $2, $3, $4, 1 + COUNT(*)
+FROM job_failures
+WHERE job_name = $1
+AND failed_at > COALESCE((SELECT MAX(finished_at) FROM job_runs WHERE job_name = $1 AND status = $5), '1970-01-01T00:00:00Z'::TIMESTAMPTZ)
+`,
+		job.Name,
+		params,
+		jobErr.Error(),
+		time.Now(),
+		jobStatusSuccess,
+	)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to record job failure", slog.String("err", err.Error()))
+	}
+}
+
+// lastSuccessfulJobRun returns the time the given job last finished successfully, or the zero time if it never did
+func lastSuccessfulJobRun(ctx context.Context, conn *pgx.Conn, jobName string) (time.Time, error) {
+	var finishedAt time.Time
+	err := conn.QueryRow(
+		ctx,
+		"SELECT finished_at FROM job_runs WHERE job_name = $1 AND status = $2 ORDER BY finished_at DESC LIMIT 1",
+		jobName,
+		jobStatusSuccess,
+	).Scan(&finishedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, nil
+	}
+
+	return finishedAt, err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// expectedSchema lists the tables and columns the jobs of this package rely on
+var expectedSchema = map[string][]string{
+	"account_federation_sessions":       {"expiration_time"},
+	"client_authorizations":             {"authorization_code_expires_at", "access_token_expires_at", "refresh_token_expires_at", "last_update_time"},
+	"client_authorization_gw2_accounts": {"account_id", "gw2_account_id"},
+	"gw2_accounts":                      {"account_id", "gw2_account_id", "gw2_account_name", "creation_time", "last_name_check_time"},
+	"gw2_account_api_tokens":            {"account_id", "gw2_account_id", "gw2_api_token", "last_valid_time", "last_valid_check_time"},
+	"gw2_account_verifications":         {"account_id", "gw2_account_id"},
+	"gw2_api_subtokens":                 {"account_id", "gw2_account_id", "gw2_api_permissions_bit_set", "gw2_api_subtoken", "expiration_time", "last_refresh_attempt_time"},
+	"background_job_locks":              {"job_name", "owner", "locked_until"},
+	"job_runs":                          {"job_name", "started_at", "finished_at", "status", "result"},
+	"job_failures":                      {"job_name", "params", "error", "failed_at", "attempt"},
+	"account_gw2_account_counts":        {"account_id", "gw2_account_count", "update_time"},
+}
+
+type validateSchemaResult struct {
+	Missing []string `json:"missing,omitempty"`
+}
+
+// validateSchema checks that all tables and columns in expectedSchema exist and fails listing the missing ones
+func validateSchema(ctx context.Context, db *dbConn, job JobExecutionRequest) (any, error) {
+	if err := job.decodeParams(&struct{}{}); err != nil {
+		return nil, err
+	}
+
+	conn, err := db.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]string, 0, len(expectedSchema))
+	for table := range expectedSchema {
+		tables = append(tables, table)
+	}
+
+	rows, err := conn.Query(
+		ctx,
+		"SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ANY($1)",
+		tables,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err = rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+
+		existing[table+"."+column] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var result validateSchemaResult
+	for table, columns := range expectedSchema {
+		for _, column := range columns {
+			if !existing[table+"."+column] {
+				result.Missing = append(result.Missing, table+"."+column)
+			}
+		}
+	}
+
+	if len(result.Missing) > 0 {
+		sort.Strings(result.Missing)
+		return result, errors.New("missing columns: " + strings.Join(result.Missing, ", "))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSubtokenRefreshBefore = time.Hour
+	defaultSubtokenValidity      = time.Hour * 24
+	defaultSubtokenLimit         = 100
+	// subtokenDeadlineMargin is the remaining time below which no further subtoken is refreshed;
+	// it covers a GW2 API call including its retries
+	subtokenDeadlineMargin = time.Second * 15
+)
+
+// gw2ApiPermissions maps the bits of gw2_api_permissions_bit_set to GW2 API permission names.
+// The bit index is the position within this slice. It mirrors the Gw2ApiPermission enum of gw2auth.com
+// (github.com/gw2auth/oauth2-server), which stores each permission at the bit of its ordinal,
+// so both lists must be kept in the same order.
+var gw2ApiPermissions = []string{
+	"account",
+	"builds",
+	"characters",
+	"guilds",
+	"inventories",
+	"progression",
+	"pvp",
+	"tradingpost",
+	"unlocks",
+	"wallet",
+	"wvw",
+}
+
+type refreshSubtokensParams struct {
+	// RefreshBefore refreshes subtokens expiring within this duration
+	RefreshBefore Duration `json:"refreshBefore"`
+	// Validity is the lifetime of newly created subtokens
+	Validity Duration `json:"validity"`
+	// Limit is the maximum number of subtokens refreshed per run
+	Limit int `json:"limit"`
+}
+
+type refreshSubtokensResult struct {
+	Refreshed     int `json:"refreshed"`
+	Skipped       int `json:"skipped"`
+	InvalidParent int `json:"invalidParent"`
+	Removed       int `json:"removed"`
+	Failed        int `json:"failed"`
+}
+
+type expiringSubtoken struct {
+	accountId      string
+	gw2AccountId   string
+	permissionsBit int
+	expirationTime time.Time
+	parentToken    string
+}
+
+// refreshSubtokens replaces subtokens which are about to expire with new ones created from their parent API token.
+// If the parent token has become invalid, the subtoken is removed and the parent is marked as checked,
+// so the next validity check picks it up as invalid. Subtokens whose parent lacks a required scope can never be
+// refreshed and are removed as well. Every other failure, including other 4xx responses which are more likely caused
+// by a bad request on our side, only records the attempt, so failing subtokens move behind the ones not tried recently.
+func refreshSubtokens(ctx context.Context, db *dbConn, job JobExecutionRequest) (any, error) {
+	params := refreshSubtokensParams{
+		RefreshBefore: Duration(defaultSubtokenRefreshBefore),
+		Validity:      Duration(defaultSubtokenValidity),
+		Limit:         defaultSubtokenLimit,
+	}
+	if err := job.decodeParams(&params); err != nil {
+		return nil, err
+	}
+
+	if params.Limit < 1 {
+		return nil, errors.New("limit must be positive")
+	}
+
+	conn, err := db.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subtokens, err := loadExpiringSubtokens(ctx, conn, time.Now().Add(time.Duration(params.RefreshBefore)), params.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var result refreshSubtokensResult
+	for _, st := range subtokens {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < subtokenDeadlineMargin {
+			slog.WarnContext(ctx, "stopping early because the deadline is near", slog.Int("refreshed", result.Refreshed))
+			break
+		}
+
+		expiresAt := time.Now().Add(time.Duration(params.Validity)).Truncate(time.Second)
+		subtoken, err := createSubtoken(ctx, st.parentToken, st.permissionsBit, expiresAt)
+
+		switch {
+		case err == nil:
+			// a subtoken stored concurrently which lives longer than the new one is kept
+			tag, err := conn.Exec(
+				ctx,
+				"UPDATE gw2_api_subtokens SET gw2_api_subtoken = $4, expiration_time = $5, last_refresh_attempt_time = $6 WHERE account_id = $1 AND gw2_account_id = $2 AND gw2_api_permissions_bit_set = $3 AND expiration_time < $5",
+				st.accountId,
+				st.gw2AccountId,
+				st.permissionsBit,
+				subtoken,
+				expiresAt,
+				time.Now(),
+			)
+			if err != nil {
+				return result, err
+			} else if tag.RowsAffected() < 1 {
+				slog.InfoContext(ctx, "subtoken was changed concurrently, skipping", slog.String("account_id", st.accountId), slog.String("gw2_account_id", st.gw2AccountId))
+				result.Skipped++
+				continue
+			}
+
+			result.Refreshed++
+
+		case errors.Is(err, ErrInvalidToken):
+			if err = markParentInvalid(ctx, conn, st); err != nil {
+				return result, err
+			}
+
+			slog.InfoContext(ctx, "parent token of subtoken is invalid", slog.String("account_id", st.accountId), slog.String("gw2_account_id", st.gw2AccountId))
+			result.InvalidParent++
+
+		case errors.Is(err, ErrMissingScope):
+			// only remove the subtoken if it was not refreshed concurrently
+			_, dbErr := conn.Exec(
+				ctx,
+				"DELETE FROM gw2_api_subtokens WHERE account_id = $1 AND gw2_account_id = $2 AND gw2_api_permissions_bit_set = $3 AND expiration_time <= $4",
+				st.accountId,
+				st.gw2AccountId,
+				st.permissionsBit,
+				st.expirationTime,
+			)
+			if dbErr != nil {
+				return result, dbErr
+			}
+
+			slog.InfoContext(ctx, "removed subtoken whose parent token is missing a scope", slog.String("account_id", st.accountId), slog.String("gw2_account_id", st.gw2AccountId), slog.String("err", err.Error()))
+			result.Removed++
+
+		default:
+			slog.WarnContext(ctx, "failed to refresh subtoken", slog.String("account_id", st.accountId), slog.String("gw2_account_id", st.gw2AccountId), slog.String("err", err.Error()))
+			result.Failed++
+
+			_, dbErr := conn.Exec(
+				ctx,
+				"UPDATE gw2_api_subtokens SET last_refresh_attempt_time = $4 WHERE account_id = $1 AND gw2_account_id = $2 AND gw2_api_permissions_bit_set = $3",
+				st.accountId,
+				st.gw2AccountId,
+				st.permissionsBit,
+				time.Now(),
+			)
+			if dbErr != nil {
+				return result, dbErr
+			}
+		}
+	}
+
+	slog.InfoContext(ctx, "refreshed subtokens", slog.Int("refreshed", result.Refreshed), slog.Int("skipped", result.Skipped), slog.Int("invalidParent", result.InvalidParent), slog.Int("removed", result.Removed), slog.Int("failed", result.Failed))
+	return result, nil
+}
+
+func loadExpiringSubtokens(ctx context.Context, conn *pgx.Conn, expiresBefore time.Time, limit int) ([]expiringSubtoken, error) {
+	rows, err := conn.Query(
+		ctx,
+		`
+SELECT st.account_id, st.gw2_account_id, st.gw2_api_permissions_bit_set, st.expiration_time, tk.gw2_api_token
+FROM gw2_api_subtokens st
+INNER JOIN gw2_account_api_tokens tk
+ON st.account_id = tk.account_id AND st.gw2_account_id = tk.gw2_account_id
+WHERE st.expiration_time <= $1
+ORDER BY st.last_refresh_attempt_time ASC NULLS FIRST, st.expiration_time ASC
+LIMIT $2
+`,
+		expiresBefore,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subtokens := make([]expiringSubtoken, 0)
+	for rows.Next() {
+		var st expiringSubtoken
+		if err = rows.Scan(&st.accountId, &st.gw2AccountId, &st.permissionsBit, &st.expirationTime, &st.parentToken); err != nil {
+			return nil, err
+		}
+
+		subtokens = append(subtokens, st)
+	}
+
+	return subtokens, rows.Err()
+}
+
+func markParentInvalid(ctx context.Context, conn *pgx.Conn, st expiringSubtoken) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "DELETE FROM gw2_api_subtokens WHERE account_id = $1 AND gw2_account_id = $2 AND gw2_api_permissions_bit_set = $3", st.accountId, st.gw2AccountId, st.permissionsBit)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE gw2_account_api_tokens SET last_valid_check_time = $3 WHERE account_id = $1 AND gw2_account_id = $2 AND last_valid_check_time < $3", st.accountId, st.gw2AccountId, time.Now())
+		return err
+	})
+}
+
+func createSubtoken(ctx context.Context, token string, permissionsBit int, expiresAt time.Time) (string, error) {
+	query := make(url.Values)
+	query.Set("expire", expiresAt.UTC().Format(time.RFC3339))
+	query.Set("permissions", strings.Join(gw2ApiPermissionNames(permissionsBit), ","))
+
+	var res struct {
+		Subtoken string `json:"subtoken"`
+	}
+	if err := gw2Get(ctx, "/v2/createsubtoken", query, token, &res); err != nil {
+		return "", err
+	}
+
+	return res.Subtoken, nil
+}
+
+// gw2ApiPermissionNames returns the names of all permissions set in the given gw2_api_permissions_bit_set, in bit order
+func gw2ApiPermissionNames(permissionsBit int) []string {
+	permissions := make([]string, 0, len(gw2ApiPermissions))
+	for i, permission := range gw2ApiPermissions {
+		if permissionsBit&(1<<i) != 0 {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	return permissions
+}
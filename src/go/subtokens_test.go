@@ -0,0 +1,37 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGw2ApiPermissionNames(t *testing.T) {
+	tests := []struct {
+		bitSet int
+		want   []string
+	}{
+		{bitSet: 0, want: []string{}},
+		{bitSet: 1 << 0, want: []string{"account"}},
+		{bitSet: 1 << 1, want: []string{"builds"}},
+		{bitSet: 1 << 2, want: []string{"characters"}},
+		{bitSet: 1 << 3, want: []string{"guilds"}},
+		{bitSet: 1 << 4, want: []string{"inventories"}},
+		{bitSet: 1 << 5, want: []string{"progression"}},
+		{bitSet: 1 << 6, want: []string{"pvp"}},
+		{bitSet: 1 << 7, want: []string{"tradingpost"}},
+		{bitSet: 1 << 8, want: []string{"unlocks"}},
+		{bitSet: 1 << 9, want: []string{"wallet"}},
+		{bitSet: 1 << 10, want: []string{"wvw"}},
+		{bitSet: 1<<0 | 1<<4 | 1<<9, want: []string{"account", "inventories", "wallet"}},
+		{bitSet: 1<<10 | 1<<2 | 1<<0, want: []string{"account", "characters", "wvw"}},
+		{bitSet: 1<<11 - 1, want: []string{"account", "builds", "characters", "guilds", "inventories", "progression", "pvp", "tradingpost", "unlocks", "wallet", "wvw"}},
+		// bits beyond the known permissions are ignored
+		{bitSet: 1<<11 | 1<<0, want: []string{"account"}},
+	}
+
+	for _, tt := range tests {
+		if got := gw2ApiPermissionNames(tt.bitSet); !slices.Equal(got, tt.want) {
+			t.Errorf("gw2ApiPermissionNames(%b) = %v, want %v", tt.bitSet, got, tt.want)
+		}
+	}
+}
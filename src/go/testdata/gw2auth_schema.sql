@@ -0,0 +1,46 @@
+-- minimal subset of the gw2auth.com schema used by the integration tests: only the columns read or written by the jobs
+CREATE TABLE account_federation_sessions (
+    id TEXT NOT NULL,
+    expiration_time TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE client_authorizations (
+    id UUID NOT NULL DEFAULT gen_random_uuid(),
+    authorization_code_expires_at TIMESTAMPTZ,
+    access_token_expires_at TIMESTAMPTZ,
+    refresh_token_expires_at TIMESTAMPTZ,
+    last_update_time TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE gw2_accounts (
+    account_id UUID NOT NULL,
+    gw2_account_id TEXT NOT NULL,
+    gw2_account_name TEXT NOT NULL,
+    creation_time TIMESTAMPTZ NOT NULL,
+    last_name_check_time TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (account_id, gw2_account_id)
+);
+
+CREATE TABLE gw2_account_api_tokens (
+    account_id UUID NOT NULL,
+    gw2_account_id TEXT NOT NULL,
+    gw2_api_token TEXT NOT NULL,
+    last_valid_time TIMESTAMPTZ NOT NULL,
+    last_valid_check_time TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (account_id, gw2_account_id)
+);
+
+CREATE TABLE gw2_account_verifications (
+    account_id UUID NOT NULL,
+    gw2_account_id TEXT NOT NULL,
+    PRIMARY KEY (gw2_account_id)
+);
+
+CREATE TABLE client_authorization_gw2_accounts (
+    client_authorization_id UUID NOT NULL,
+    account_id UUID NOT NULL,
+    gw2_account_id TEXT NOT NULL,
+    PRIMARY KEY (client_authorization_id, account_id, gw2_account_id)
+);
@@ -0,0 +1,55 @@
+-- prevents overlapping runs of the same job
+CREATE TABLE IF NOT EXISTS background_job_locks (
+    job_name TEXT NOT NULL,
+    owner TEXT NOT NULL,
+    locked_until TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (job_name)
+);
+
+-- history of all job executions
+CREATE TABLE IF NOT EXISTS job_runs (
+    id UUID NOT NULL DEFAULT gen_random_uuid(),
+    job_name TEXT NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    status TEXT NOT NULL,
+    result JSONB,
+    PRIMARY KEY (id),
+    INDEX (job_name, finished_at DESC)
+);
+
+-- dead-letter of failed job executions
+CREATE TABLE IF NOT EXISTS job_failures (
+    id UUID NOT NULL DEFAULT gen_random_uuid(),
+    job_name TEXT NOT NULL,
+    params JSONB,
+    error TEXT NOT NULL,
+    failed_at TIMESTAMPTZ NOT NULL,
+    attempt INT NOT NULL,
+    PRIMARY KEY (id),
+    INDEX (job_name, failed_at DESC)
+);
+
+-- subtokens refreshed by REFRESH_SUBTOKENS
+CREATE TABLE IF NOT EXISTS gw2_api_subtokens (
+    account_id UUID NOT NULL,
+    gw2_account_id TEXT NOT NULL,
+    gw2_api_permissions_bit_set INT NOT NULL,
+    gw2_api_subtoken TEXT NOT NULL,
+    expiration_time TIMESTAMPTZ NOT NULL,
+    last_refresh_attempt_time TIMESTAMPTZ,
+    PRIMARY KEY (account_id, gw2_account_id, gw2_api_permissions_bit_set),
+    INDEX (expiration_time)
+);
+
+-- added after the initial version of gw2_api_subtokens
+ALTER TABLE gw2_api_subtokens ADD COLUMN IF NOT EXISTS last_refresh_attempt_time TIMESTAMPTZ;
+
+-- number of connected GW2 accounts per account, maintained by REFRESH_ACCOUNT_COUNTS
+CREATE TABLE IF NOT EXISTS account_gw2_account_counts (
+    account_id UUID NOT NULL,
+    gw2_account_count INT NOT NULL,
+    update_time TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (account_id),
+    INDEX (update_time)
+);